@@ -18,24 +18,25 @@ import (
 
 //. Writer
 
+const blocksize = 58315
+
 /*
 Levels range from 1 (BestSpeed) to 9 (BestCompression), Level 0 (NoCompression), -1 (DefaultCompression)
 */
 func Write(r io.Reader, filename string, level int) error {
 
-	const blocksize = 58315
-
-	crc := crc32.NewIEEE()
-	isize := 0
+	fp, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
 
-	var buf bytes.Buffer
-	fw, err := flate.NewWriter(&buf, level)
+	w, err := NewWriter(fp, level)
 	if err != nil {
 		return err
 	}
-	sizes := make([]int, 0)
+
 	b := make([]byte, blocksize)
-	total := 0
 	eof := false
 	for !eof {
 		n, err := readfull(r, b)
@@ -47,26 +48,317 @@ func Write(r io.Reader, filename string, level int) error {
 			}
 		}
 		if n > 0 {
-			crc.Write(b[:n])
-			isize += n
+			if _, err := w.Write(b[:n]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.Close()
+}
+
+/*
+Writer implements io.WriteCloser. It accepts a stream of bytes of arbitrary
+size, buffers it into blocksize-byte blocks and compresses each block
+independently, so that the resulting file can be randomly accessed with
+Reader.Get.
+
+Because the RA extra field has to be written at the head of the file, the
+compressed blocks are buffered in memory and the whole file is written out
+on Close.
+*/
+type Writer struct {
+	w         io.Writer
+	level     int
+	blockSize int // blocksize, unless overridden by tests
+
+	in    []byte // bytes not yet compressed, always shorter than blockSize
+	out   bytes.Buffer
+	sizes []int
+
+	fw     Resetter // used when concurrency is 1
+	crcVal uint32
+	isize  int
+
+	concurrency int
+	sem         chan struct{}
+	futures     []chan blockResult
+
+	closed bool
+
+	// lastBlockShort is set once a block shorter than blockSize has been
+	// appended (via Close's final flush or CopyBlock). Reader.Get locates
+	// a block with start/blocksize, which is only correct if every
+	// non-final block is exactly blockSize bytes, so nothing may be
+	// appended after a short block.
+	lastBlockShort bool
+}
+
+// blockResult is the outcome of compressing one block on a worker goroutine.
+type blockResult struct {
+	data []byte
+	err  error
+}
+
+/*
+NewWriter creates a new Writer that writes a dictzip file to w, compressing
+at the given level. Levels range from 1 (BestSpeed) to 9
+(BestCompression), Level 0 (NoCompression), -1 (DefaultCompression).
+*/
+func NewWriter(w io.Writer, level int) (*Writer, error) {
+	z := &Writer{
+		w:         w,
+		level:     level,
+		blockSize: blocksize,
+	}
+	fw, err := newCompressor(&z.out, level)
+	if err != nil {
+		return nil, err
+	}
+	z.fw = fw
+	return z, nil
+}
 
-			fw.Write(b[:n])
-			fw.Flush()
-			fw.Reset(&buf)
+// Write buffers p and compresses every full blocksize-byte block it completes.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, fmt.Errorf("Write called after Close")
+	}
+	if z.lastBlockShort {
+		return 0, fmt.Errorf("Write called after a short block was already appended")
+	}
 
-			l := buf.Len()
-			sizes = append(sizes, l-total)
-			total = l
+	n := len(p)
+	z.in = append(z.in, p...)
+	for len(z.in) >= z.blockSize {
+		if err := z.compressBlock(z.in[:z.blockSize]); err != nil {
+			return 0, err
 		}
+		z.in = z.in[z.blockSize:]
 	}
-	fw.Close()
+	return n, nil
+}
 
-	fp, err := os.Create(filename)
-	if err != nil {
+func (z *Writer) compressBlock(b []byte) error {
+	z.crcVal = crc32Combine(z.crcVal, crc32.ChecksumIEEE(b), int64(len(b)))
+	z.isize += len(b)
+	if len(b) != z.blockSize {
+		z.lastBlockShort = true
+	}
+
+	if z.concurrency <= 1 {
+		total := z.out.Len()
+		z.fw.Write(b)
+		z.fw.Flush()
+		z.fw.Reset(&z.out)
+
+		z.sizes = append(z.sizes, z.out.Len()-total)
+		return nil
+	}
+
+	cp := make([]byte, len(b))
+	copy(cp, b)
+
+	ch := make(chan blockResult, 1)
+	z.futures = append(z.futures, ch)
+
+	z.sem <- struct{}{}
+	go func() {
+		defer func() { <-z.sem }()
+		var buf bytes.Buffer
+		fw, err := newCompressor(&buf, z.level)
+		if err != nil {
+			ch <- blockResult{err: err}
+			return
+		}
+		fw.Write(cp)
+		fw.Flush()
+		ch <- blockResult{data: buf.Bytes()}
+	}()
+	return nil
+}
+
+// drainFutures waits for every block dispatched to a worker goroutine to
+// finish compressing and appends its result to z.out/z.sizes in order,
+// leaving the Writer quiescent. Callers that append to z.out/z.sizes
+// directly, such as CopyBlock, must call this first so a block queued by a
+// concurrent Write can never land after one appended out of band.
+func (z *Writer) drainFutures() error {
+	for _, ch := range z.futures {
+		res := <-ch
+		if res.err != nil {
+			return res.err
+		}
+		z.sizes = append(z.sizes, len(res.data))
+		z.out.Write(res.data)
+	}
+	z.futures = nil
+	return nil
+}
+
+/*
+SetConcurrency configures the Writer to compress up to n blocks in
+parallel, each on its own goroutine with its own compressor producing a
+self-contained deflate stream. Results are reassembled in block order, so
+the output is identical to the one produced with concurrency 1. It must
+be called before the first call to Write. The default concurrency is 1.
+*/
+func (z *Writer) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	z.concurrency = n
+	if n > 1 {
+		z.sem = make(chan struct{}, n)
+	}
+}
+
+/*
+Close flushes any buffered bytes as a final, short block, writes the
+header with the RA extra field and the compressed data to the underlying
+io.Writer, and writes the gzip trailer.
+*/
+func (z *Writer) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+
+	if len(z.in) > 0 {
+		if err := z.compressBlock(z.in); err != nil {
+			return err
+		}
+		z.in = nil
+	}
+
+	if err := z.drainFutures(); err != nil {
 		return err
 	}
-	defer fp.Close()
 
+	// z.fw was reset to write into z.out after the last block it
+	// compressed (or never used at all, if concurrency > 1), so closing it
+	// now appends nothing but a final BFINAL=1 block, terminating the
+	// deflate stream so the file is a well-formed gzip member and not just
+	// something this package's own Get/ReadAt can make sense of.
+	if err := z.fw.Close(); err != nil {
+		return err
+	}
+
+	if err := writeHeader(z.w, z.level, z.blockSize, z.sizes); err != nil {
+		return err
+	}
+
+	if _, err := z.w.Write(z.out.Bytes()); err != nil {
+		return err
+	}
+
+	return writeTrailer(z.w, z.crcVal, z.isize)
+}
+
+/*
+CopyBlock appends an already-compressed block's raw deflate bytes
+directly to the output, without inflating and recompressing it. rawLen is
+the length of the block before compression, needed to keep the trailer's
+ISIZE consistent and to tell a short, final block from a full one; the
+size table records len(compressed). CopyBlock does not update the
+trailer's CRC-32; CopyFrom does. It must be called on a
+block boundary, i.e. not while a partial block is pending from Write.
+
+If SetConcurrency is in effect, any block already dispatched to a worker
+by Write is drained first, so CopyBlock only ever appends to a quiescent
+queue and blocks can never be reordered relative to one another.
+
+Reader.Get locates a block by dividing the requested offset by the
+nominal block size, which is only correct if every block but the last in
+the whole file is exactly blockSize bytes long. So rawLen must equal
+blockSize for every call except the last one, and CopyBlock rejects any
+further call, from either CopyBlock or Write, once a short block has
+been appended.
+*/
+func (z *Writer) CopyBlock(compressed []byte, rawLen int) error {
+	if z.closed {
+		return fmt.Errorf("CopyBlock called after Close")
+	}
+	if len(z.in) > 0 {
+		return fmt.Errorf("CopyBlock called with a partial block pending")
+	}
+	if z.lastBlockShort {
+		return fmt.Errorf("CopyBlock called after a short block was already appended")
+	}
+	if rawLen != z.blockSize {
+		z.lastBlockShort = true
+	}
+
+	if err := z.drainFutures(); err != nil {
+		return err
+	}
+
+	z.sizes = append(z.sizes, len(compressed))
+	z.out.Write(compressed)
+	z.isize += rawLen
+	return nil
+}
+
+/*
+CopyFrom appends every block of src to z, copying the already-compressed
+deflate bytes verbatim via CopyBlock and folding src's CRC-32 and
+uncompressed size into z's running totals, without inflating or
+recompressing a single block. It must be called on a block boundary, i.e.
+not while a partial block is pending from Write.
+
+src must use the same block size as z, checked up front so a mismatch is
+rejected before any bytes are written rather than discovered partway
+through the copy (or, for a single-block src, not at all). Every block of
+src but its last must be exactly z.blockSize bytes raw, the same
+restriction CopyBlock places on its callers, or CopyFrom fails with an
+error from CopyBlock. In particular, calling CopyFrom more than once only
+works if every source but the last one ends on an exact multiple of
+z.blockSize; a merge that doesn't should be re-blocked through Write
+instead, which reinflates and recompresses.
+*/
+func (z *Writer) CopyFrom(src *Reader) error {
+	if src.blocksize != int64(z.blockSize) {
+		return fmt.Errorf("dictzip: CopyFrom: source block size %d does not match destination block size %d", src.blocksize, z.blockSize)
+	}
+
+	blockcnt := len(src.offsets) - 1
+	for i := 0; i < blockcnt; i++ {
+		r, _, err := src.OpenRawBlock(i)
+		if err != nil {
+			return err
+		}
+		compressed, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		rawLen := int(src.blocksize)
+		if i == blockcnt-1 {
+			rawLen = int(src.isize - src.blocksize*int64(blockcnt-1))
+		}
+
+		if err := z.CopyBlock(compressed, rawLen); err != nil {
+			return err
+		}
+	}
+
+	z.crcVal = crc32Combine(z.crcVal, src.crc32, src.isize)
+	return nil
+}
+
+/*
+maxBlocksPerRA is the most block sizes a single RA subfield can carry
+before its own length prefix (a uint16) would overflow. It is a var, not
+a const, purely so tests can shrink it and exercise chaining into several
+RA subfields.
+
+At the default value a file never gets a second RA subfield: the gzip
+extra field's own 16-bit XLEN caps all subfields together, so writeHeader
+rejects any file of more than 32762 blocks.
+*/
+var maxBlocksPerRA = (65535 - 6) / 2
+
+func writeHeader(w io.Writer, level, blockSize int, sizes []int) error {
 	xfl := byte(0)
 	if level == flate.BestCompression {
 		xfl = 2
@@ -74,7 +366,7 @@ func Write(r io.Reader, filename string, level int) error {
 		xfl = 4
 	}
 	now := time.Now().Unix()
-	_, err = fp.Write([]byte{
+	_, err := w.Write([]byte{
 		31, 139, 8, 4,
 		byte(now & 255), byte((now >> 8) & 255), byte((now >> 16) & 255), byte((now >> 24) & 255),
 		xfl, 255})
@@ -82,40 +374,138 @@ func Write(r io.Reader, filename string, level int) error {
 		return err
 	}
 
-	xlen := 10 + 2*len(sizes)
-	ln := 6 + 2*len(sizes)
-	_, err = fp.Write([]byte{
-		byte(xlen & 255), byte((xlen >> 8) & 255),
-		'R', 'A', byte(ln & 255), byte((ln >> 8) & 255),
-		1, 0,
-		byte(blocksize & 255), byte((blocksize >> 8) & 255),
-		byte(len(sizes) & 255), byte((len(sizes) >> 8) & 255)})
+	chunks := chunkSizes(sizes, maxBlocksPerRA)
+
+	xlen := 0
+	for _, c := range chunks {
+		xlen += 4 + 6 + 2*len(c)
+	}
+	if xlen > 65535 {
+		return fmt.Errorf("dictzip: %d blocks do not fit in a gzip extra field", len(sizes))
+	}
+
+	_, err = w.Write([]byte{byte(xlen & 255), byte((xlen >> 8) & 255)})
 	if err != nil {
 		return err
 	}
-	for _, o := range sizes {
-		_, err = fp.Write([]byte{byte(o & 255), byte((o >> 8) & 255)})
+
+	for _, c := range chunks {
+		ln := 6 + 2*len(c)
+		_, err = w.Write([]byte{
+			'R', 'A', byte(ln & 255), byte((ln >> 8) & 255),
+			1, 0,
+			byte(blockSize & 255), byte((blockSize >> 8) & 255),
+			byte(len(c) & 255), byte((len(c) >> 8) & 255)})
 		if err != nil {
 			return err
 		}
+		for _, o := range c {
+			_, err = w.Write([]byte{byte(o & 255), byte((o >> 8) & 255)})
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	_, err = fp.Write(buf.Bytes())
-	if err != nil {
-		return err
+// chunkSizes splits sizes into consecutive groups of at most max entries
+// each, so every group fits in one RA subfield. It always returns at
+// least one (possibly empty) group, so a zero-block file still gets a
+// well-formed RA subfield.
+func chunkSizes(sizes []int, max int) [][]int {
+	if max <= 0 {
+		max = len(sizes)
+	}
+	if len(sizes) == 0 {
+		return [][]int{{}}
 	}
 
-	c := crc.Sum32()
-	_, err = fp.Write([]byte{
+	var chunks [][]int
+	for len(sizes) > 0 {
+		n := max
+		if n > len(sizes) {
+			n = len(sizes)
+		}
+		chunks = append(chunks, sizes[:n])
+		sizes = sizes[n:]
+	}
+	return chunks
+}
+
+func writeTrailer(w io.Writer, c uint32, isize int) error {
+	_, err := w.Write([]byte{
 		byte(c & 255), byte((c >> 8) & 255), byte((c >> 16) & 255), byte((c >> 24) & 255),
 		byte(isize & 255), byte((isize >> 8) & 255), byte((isize >> 16) & 255), byte((isize >> 24) & 255),
 	})
-	if err != nil {
-		return err
-	}
+	return err
+}
 
-	return nil
+//. Compressor/decompressor registry
+
+/*
+Resetter is the subset of *flate.Writer that Writer relies on: it can be
+redirected to a new destination without reallocating, so one compressor
+can be reused block after block, and Close terminates the deflate stream
+with a final block so the file is a well-formed gzip member. Types
+registered with RegisterCompressor must implement it.
+*/
+type Resetter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(dst io.Writer)
+}
 
+var (
+	registryLock sync.RWMutex
+	compressors  = map[int]func(io.Writer) (Resetter, error){}
+	decompressor func(io.Reader) io.ReadCloser
+)
+
+/*
+RegisterCompressor registers a compressor for the given flate level,
+overriding the stdlib compress/flate implementation the Writer otherwise
+uses for that level. This mirrors archive/zip's RegisterCompressor and
+lets a caller substitute e.g. github.com/klauspost/compress/flate for the
+stdlib one without forking this package.
+*/
+func RegisterCompressor(level int, fn func(io.Writer) (Resetter, error)) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	compressors[level] = fn
+}
+
+/*
+RegisterDecompressor registers the decompressor Reader uses to open
+blocks, overriding the stdlib compress/flate implementation. Every block
+in a dictzip file is plain deflate regardless of the level used to write
+it, so unlike RegisterCompressor there is a single decompressor slot
+rather than one per level.
+*/
+func RegisterDecompressor(fn func(io.Reader) io.ReadCloser) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	decompressor = fn
+}
+
+func newCompressor(dst io.Writer, level int) (Resetter, error) {
+	registryLock.RLock()
+	fn, ok := compressors[level]
+	registryLock.RUnlock()
+	if ok {
+		return fn(dst)
+	}
+	return flate.NewWriter(dst, level)
+}
+
+func newDecompressor(r io.Reader) io.ReadCloser {
+	registryLock.RLock()
+	fn := decompressor
+	registryLock.RUnlock()
+	if fn != nil {
+		return fn(r)
+	}
+	return flate.NewReader(r)
 }
 
 //. Reader
@@ -125,6 +515,12 @@ type Reader struct {
 	offsets   []int64
 	blocksize int64
 	lock      sync.Mutex
+
+	// crc32 and isize hold the gzip trailer fields, read once at open
+	// time so Writer.CopyFrom can fold them into a copy's running totals
+	// without decompressing the file.
+	crc32 uint32
+	isize int64
 }
 
 func NewReader(rs io.ReadSeeker) (*Reader, error) {
@@ -136,7 +532,12 @@ func NewReader(rs io.ReadSeeker) (*Reader, error) {
 		return nil, err
 	}
 
-	metadata := []byte{}
+	// version, blocksize and the concatenated per-block size table, built
+	// up across every RA subfield found in the extra field (there may be
+	// more than one if the block count overflowed a single subfield).
+	haveMetadata := false
+	var version int
+	sizeTable := []byte{}
 
 	p := 0
 
@@ -152,7 +553,7 @@ func NewReader(rs io.ReadSeeker) (*Reader, error) {
 	}
 
 	if h[2] != 8 {
-		return nil, fmt.Errorf("Unknown compression method:", h[2])
+		return nil, fmt.Errorf("Unknown compression method: %d", h[2])
 	}
 
 	flg := h[3]
@@ -179,7 +580,24 @@ func NewReader(rs io.ReadSeeker) (*Reader, error) {
 			ln := int(h[q+2]) + 256*int(h[q+3])
 
 			if si1 == 'R' && si2 == 'A' {
-				metadata = h[q+4 : q+4+ln]
+				data := h[q+4 : q+4+ln]
+				if len(data) < 6 {
+					return nil, fmt.Errorf("Missing dictzip metadata")
+				}
+
+				v := int(data[0]) + 256*int(data[1])
+				bs := int64(data[2]) + 256*int64(data[3])
+				cnt := int(data[4]) + 256*int(data[5])
+
+				if !haveMetadata {
+					version = v
+					dz.blocksize = bs
+					haveMetadata = true
+				} else if v != version || bs != dz.blocksize {
+					return nil, fmt.Errorf("Inconsistent dictzip metadata across chained RA subfields")
+				}
+
+				sizeTable = append(sizeTable, data[6:6+2*cnt]...)
 			}
 
 			q += 4 + ln
@@ -213,25 +631,32 @@ func NewReader(rs io.ReadSeeker) (*Reader, error) {
 		p += n
 	}
 
-	if len(metadata) < 6 {
+	if !haveMetadata {
 		return nil, fmt.Errorf("Missing dictzip metadata")
 	}
 
-	version := int(metadata[0]) + 256*int(metadata[1])
-
 	if version != 1 {
-		return nil, fmt.Errorf("Unknown dictzip version:", version)
+		return nil, fmt.Errorf("Unknown dictzip version: %d", version)
 	}
 
-	dz.blocksize = int64(metadata[2]) + 256*int64(metadata[3])
-	blockcnt := int(metadata[4]) + 256*int(metadata[5])
+	blockcnt := len(sizeTable) / 2
 
 	dz.offsets = make([]int64, blockcnt+1)
 	dz.offsets[0] = int64(p)
 	for i := 0; i < blockcnt; i++ {
-		dz.offsets[i+1] = dz.offsets[i] + int64(metadata[6+2*i]) + 256*int64(metadata[7+2*i])
+		dz.offsets[i+1] = dz.offsets[i] + int64(sizeTable[2*i]) + 256*int64(sizeTable[2*i+1])
 	}
 
+	if _, err := dz.fp.Seek(-8, 2); err != nil {
+		return nil, err
+	}
+	t := make([]byte, 8)
+	if _, err := readfull(dz.fp, t); err != nil {
+		return nil, err
+	}
+	dz.crc32 = uint32(t[0]) | uint32(t[1])<<8 | uint32(t[2])<<16 | uint32(t[3])<<24
+	dz.isize = int64(t[4]) | int64(t[5])<<8 | int64(t[6])<<16 | int64(t[7])<<24
+
 	return dz, nil
 
 }
@@ -248,7 +673,7 @@ func (dz *Reader) Get(start, size int64) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	rd := flate.NewReader(dz.fp)
+	rd := newDecompressor(dz.fp)
 
 	data := make([]byte, size1)
 	_, err = readfull(rd, data)
@@ -259,6 +684,76 @@ func (dz *Reader) Get(start, size int64) ([]byte, error) {
 	return data[start-start1:], nil
 }
 
+// Size returns the total uncompressed size of the dictzip file, as
+// recorded in the gzip trailer's ISIZE field at open time.
+func (dz *Reader) Size() int64 {
+	return dz.isize
+}
+
+/*
+ReadAt implements io.ReaderAt, so a *Reader can be used directly with
+io.SectionReader, http.ServeContent and other stdlib facilities expecting
+random access to a byte range. It decodes via Get, which already spans
+multiple blocks as needed, and clips p to Size(), returning io.EOF once
+off reaches or passes the end of the file.
+*/
+func (dz *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("dictzip: negative offset")
+	}
+	if off >= dz.isize {
+		return 0, io.EOF
+	}
+
+	size := int64(len(p))
+	if off+size > dz.isize {
+		size = dz.isize - off
+	}
+
+	data, err := dz.Get(off, size)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+
+	if int64(n) < int64(len(p)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+/*
+OpenRawBlock returns the still-compressed deflate bytes of block i,
+together with their length, without inflating them. This lets a block be
+appended verbatim to another dictzip file with Writer.CopyBlock or
+Writer.CopyFrom, skipping the cost of decompressing and recompressing it.
+*/
+func (dz *Reader) OpenRawBlock(i int) (io.Reader, int64, error) {
+
+	dz.lock.Lock()
+	defer dz.lock.Unlock()
+
+	if i < 0 || i >= len(dz.offsets)-1 {
+		return nil, 0, fmt.Errorf("dictzip: block index out of range: %d", i)
+	}
+
+	start := dz.offsets[i]
+	length := dz.offsets[i+1] - start
+
+	_, err := dz.fp.Seek(start, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	raw := make([]byte, length)
+	_, err = readfull(dz.fp, raw)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bytes.NewReader(raw), length, nil
+}
+
 // Using start and size in base64 notation, such as used by the dictunzip program.
 func (dz *Reader) GetB64(start, size string) ([]byte, error) {
 	start2, err := decode(start)
@@ -274,6 +769,71 @@ func (dz *Reader) GetB64(start, size string) ([]byte, error) {
 
 //. Helper function
 
+/*
+crc32Combine folds two IEEE CRC-32 checksums, computed over sequential
+byte ranges, into the checksum of their concatenation, given only the
+length of the second range. This lets Writer.CopyFrom adopt the CRC-32 of
+a raw-copied source without re-reading its (already discarded) uncompressed
+bytes. It is the same GF(2) matrix-squaring construction zlib uses for
+crc32_combine.
+*/
+func crc32Combine(crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	var even, odd [32]uint32
+
+	odd[0] = 0xedb88320 // CRC-32 polynomial, reflected
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+
+	gf2MatrixSquare(&even, &odd) // one zero bit in odd -> two zero bits in even
+	gf2MatrixSquare(&odd, &even) // two zero bits in even -> four zero bits in odd
+
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat [32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i++ {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+		vec >>= 1
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := 0; n < 32; n++ {
+		square[n] = gf2MatrixTimes(*mat, mat[n])
+	}
+}
+
 func readfull(fp io.Reader, buf []byte) (int, error) {
 	ln := len(buf)
 	for p := 0; p < ln; {
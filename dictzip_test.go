@@ -0,0 +1,414 @@
+package dictzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// TestWriter verifies the basic Writer round trip of a multi-block input
+// fed as a Write smaller than a block followed by the rest, and that
+// Write after Close is rejected.
+func TestWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 10000)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.blockSize = 100
+	if _, err := w.Write(data[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Fatal("expected an error writing after Close")
+	}
+
+	rd, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rd.Size(), int64(len(data)); got != want {
+		t.Fatalf("Size() = %d, want %d", got, want)
+	}
+	got, err := rd.Get(0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("round trip through Writer produced wrong data")
+	}
+}
+
+// TestSetConcurrency verifies that compressing with SetConcurrency(n>1)
+// produces byte-identical output to the default, sequential Writer.
+func TestSetConcurrency(t *testing.T) {
+	data := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 10000)
+
+	var serial bytes.Buffer
+	w1, err := NewWriter(&serial, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w1.blockSize = 100
+	if _, err := w1.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var parallelOut bytes.Buffer
+	w2, err := NewWriter(&parallelOut, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2.blockSize = 100
+	w2.SetConcurrency(4)
+	if _, err := w2.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bytes 4-7 are the gzip header's MTIME, written from time.Now() at
+	// Close and thus free to differ between the two files; mask them out
+	// before comparing everything else byte for byte.
+	b1, b2 := append([]byte{}, serial.Bytes()...), append([]byte{}, parallelOut.Bytes()...)
+	for _, b := range [][]byte{b1, b2} {
+		b[4], b[5], b[6], b[7] = 0, 0, 0, 0
+	}
+	if !bytes.Equal(b1, b2) {
+		t.Fatal("SetConcurrency(4) produced different output than the sequential writer")
+	}
+
+	rd, err := NewReader(bytes.NewReader(parallelOut.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rd.Get(0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("round trip through a concurrently compressed file produced wrong data")
+	}
+}
+
+// TestReaderAt verifies ReadAt against an io.SectionReader, including a
+// read that straddles a block boundary and one that runs past EOF.
+func TestReaderAt(t *testing.T) {
+	data := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 10000)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.blockSize = 100
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr := io.NewSectionReader(rd, 0, rd.Size())
+	got := make([]byte, 250)
+	n, err := sr.ReadAt(got, 90) // straddles the 100-byte block boundary
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(got) || !bytes.Equal(got, data[90:90+250]) {
+		t.Fatal("ReadAt across a block boundary produced wrong data")
+	}
+
+	tail := make([]byte, 50)
+	n, err = rd.ReadAt(tail, rd.Size()-10)
+	if err != io.EOF {
+		t.Fatalf("ReadAt past EOF: err = %v, want io.EOF", err)
+	}
+	if n != 10 || !bytes.Equal(tail[:10], data[len(data)-10:]) {
+		t.Fatal("ReadAt past EOF produced wrong data")
+	}
+}
+
+// TestCopyFrom verifies that copying every block of one file into another
+// via CopyFrom round-trips, that merging two sources whose block sizes
+// line up works, and that a merge left with a short block in the middle
+// is rejected instead of silently producing an unreadable file.
+func TestCopyFrom(t *testing.T) {
+	build := func(data []byte, blockSize int) *Reader {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.blockSize = blockSize
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rd, err := NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rd
+	}
+
+	data1 := bytes.Repeat([]byte("a"), 300) // exact multiple of blockSize 100
+	data2 := bytes.Repeat([]byte("b"), 300)
+	src1 := build(data1, 100)
+	src2 := build(data2, 100)
+
+	var merged bytes.Buffer
+	w, err := NewWriter(&merged, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.blockSize = 100
+	if err := w.CopyFrom(src1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CopyFrom(src2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewReader(bytes.NewReader(merged.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte{}, data1...), data2...)
+	if got, want := rd.Size(), int64(len(want)); got != want {
+		t.Fatalf("Size() = %d, want %d", got, want)
+	}
+	// Read a range spanning the two merged sources, including bytes
+	// located in the block right after src1's boundary, to catch
+	// mis-seeked offsets for the blocks copied from src2.
+	got, err := rd.Get(250, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want[250:350]) {
+		t.Fatal("round trip through CopyFrom merge produced wrong data")
+	}
+
+	// A source whose raw length isn't an exact multiple of the
+	// destination's block size must not be followed by another CopyFrom:
+	// it would leave a short block in the middle of the file, and
+	// Reader.Get's start/blocksize arithmetic would silently misbehave.
+	oddSrc := build(bytes.Repeat([]byte("c"), 250), 100) // 250 = 2*100 + 50
+	var bad bytes.Buffer
+	w2, err := NewWriter(&bad, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2.blockSize = 100
+	if err := w2.CopyFrom(oddSrc); err != nil {
+		t.Fatal(err)
+	}
+	if err := w2.CopyFrom(src2); err == nil {
+		t.Fatal("expected an error merging another source after a short block")
+	}
+}
+
+/*
+TestChainedRA verifies that Writer chains multiple RA subfields once the
+block count overflows a single one, and that NewReader concatenates them
+back into one size table. maxBlocksPerRA and the writer's block size are
+both shrunk for the duration of the test, so it can exercise chaining
+with under a hundred bytes of data instead of the gigabytes a real
+overflow of the default 58315-byte block size would need.
+*/
+func TestChainedRA(t *testing.T) {
+	savedMax := maxBlocksPerRA
+	maxBlocksPerRA = 5
+	defer func() { maxBlocksPerRA = savedMax }()
+
+	const smallBlock = 4
+	const blockCount = 23 // > 4*maxBlocksPerRA, forces 5 chained RA subfields
+
+	data := make([]byte, smallBlock*blockCount)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.blockSize = smallBlock
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(rd.offsets)-1, blockCount; got != want {
+		t.Fatalf("block count = %d, want %d", got, want)
+	}
+	if got, want := rd.Size(), int64(len(data)); got != want {
+		t.Fatalf("Size() = %d, want %d", got, want)
+	}
+
+	got := make([]byte, len(data))
+	n, err := rd.ReadAt(got, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) || !bytes.Equal(got, data) {
+		t.Fatalf("round trip through chained RA subfields produced wrong data")
+	}
+}
+
+// TestOverflowingRA verifies writeHeader, which Close calls, reports a
+// clear error instead of silently truncating the size table when the
+// chained RA subfields together don't fit in the gzip extra field's
+// 16-bit length.
+func TestOverflowingRA(t *testing.T) {
+	savedMax := maxBlocksPerRA
+	maxBlocksPerRA = 1
+	defer func() { maxBlocksPerRA = savedMax }()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.blockSize = 1
+	// 2 blocks at maxBlocksPerRA==1 need 2 RA subfields; force the
+	// combined xlen past 65535 by inflating the per-subfield overhead
+	// through a huge synthetic size table instead of writing that much
+	// real data.
+	w.sizes = make([]int, 40000)
+	for i := range w.sizes {
+		w.sizes[i] = 1
+	}
+
+	err = writeHeader(&buf, w.level, w.blockSize, w.sizes)
+	if err == nil {
+		t.Fatal("expected an error for a size table that overflows the gzip extra field")
+	}
+}
+
+/*
+TestCompressorRegistry verifies that a registered compressor/decompressor
+pair is used in place of the stdlib compress/flate ones, and that a
+registered compressor for one level doesn't affect a level without one.
+*/
+func TestCompressorRegistry(t *testing.T) {
+	var used bool
+
+	RegisterCompressor(6, func(w io.Writer) (Resetter, error) {
+		used = true
+		return flate.NewWriter(w, 6)
+	})
+	RegisterDecompressor(func(r io.Reader) io.ReadCloser {
+		return flate.NewReader(r)
+	})
+	defer func() {
+		delete(compressors, 6)
+		decompressor = nil
+	}()
+
+	data := bytes.Repeat([]byte("registered compressor round trip "), 5000)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !used {
+		t.Fatal("registered compressor for level 6 was never invoked")
+	}
+
+	rd, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := rd.Get(10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data[10:110]) {
+		t.Fatal("round trip through registered compressor/decompressor produced wrong data")
+	}
+
+	// A level with no registered compressor still falls back to stdlib.
+	var buf2 bytes.Buffer
+	w2, err := NewWriter(&buf2, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w2.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rd2, err := NewReader(bytes.NewReader(buf2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out2, err := rd2.Get(10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out2, data[10:110]) {
+		t.Fatal("fallback to stdlib flate produced wrong data")
+	}
+}
+
+// TestCrc32Combine verifies crc32Combine against a direct CRC-32 computed
+// over the concatenated bytes, so a regression in the GF(2) folding math
+// is actually caught rather than merely agreeing with itself on both
+// sides of a serial-vs-parallel comparison.
+func TestCrc32Combine(t *testing.T) {
+	cases := []struct{ a, b []byte }{
+		{[]byte("the quick brown fox"), []byte("jumps over the lazy dog")},
+		{[]byte(""), []byte("jumps over the lazy dog")},
+		{bytes.Repeat([]byte("x"), 1), bytes.Repeat([]byte("y"), 100000)},
+		{bytes.Repeat([]byte("a"), 12345), bytes.Repeat([]byte("b"), 6789)},
+	}
+
+	for i, c := range cases {
+		got := crc32Combine(crc32.ChecksumIEEE(c.a), crc32.ChecksumIEEE(c.b), int64(len(c.b)))
+		want := crc32.ChecksumIEEE(append(append([]byte{}, c.a...), c.b...))
+		if got != want {
+			t.Fatalf("case %d: crc32Combine = %08x, want %08x", i, got, want)
+		}
+	}
+}